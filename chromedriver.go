@@ -5,33 +5,42 @@
 package chromedriver
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/daaku/go.homedir"
-	"github.com/daaku/go.httpzip"
 	"github.com/facebookgo/freeport"
 )
 
 const (
-	downloadBase = "https://chromedriver.storage.googleapis.com/"
-	binaryBase   = "chromedriver"
+	knownGoodVersionsURL     = "https://googlechromelabs.github.io/chrome-for-testing/known-good-versions-with-downloads.json"
+	lastKnownGoodVersionsURL = "https://googlechromelabs.github.io/chrome-for-testing/last-known-good-versions-with-downloads.json"
+	binaryBase               = "chromedriver"
+	manifestBase             = "manifest.json"
 )
 
 var (
 	version = flag.String(
 		"chromedriver.version",
-		"2.27",
-		"chromedriver binary version to use")
+		"latest",
+		"chromedriver version to use: a full version, a milestone "+
+			"(e.g. \"120\"), or one of \"latest\", \"stable\", \"beta\"")
 	cacheDir = flag.String(
 		"chromedriver.cache-dir",
 		filepath.Join(homedir.Get(), ".chromedriver"),
@@ -44,29 +53,271 @@ var (
 		"chromedriver.port",
 		0,
 		"port to bind chromedriver server to")
-
-	once         = &sync.Once{}
-	binaryPath   string
-	installError error
+	sha256Pin = flag.String(
+		"chromedriver.sha256",
+		"",
+		"expected sha256 of the downloaded chromedriver zip, verified "+
+			"if set")
+	startTimeout = flag.Duration(
+		"chromedriver.start-timeout",
+		30*time.Second,
+		"how long to wait for the chromedriver server to become ready")
 )
 
+// DefaultManager is the Manager used by the package-level Start and
+// StartWithOptions.
+var DefaultManager = NewManager()
+
+// Manager installs and caches chromedriver binaries. Its zero value
+// (via NewManager) reads CacheDir and SHA256Pin from the
+// chromedriver.cache-dir and chromedriver.sha256 flags at call time,
+// so multiple versions can be installed concurrently and flag changes
+// made after package init are honored.
+type Manager struct {
+	// CacheDir overrides the chromedriver.cache-dir flag when set.
+	CacheDir string
+	// SHA256Pin overrides the chromedriver.sha256 flag when set.
+	SHA256Pin string
+
+	onces   sync.Map // installKey -> *sync.Once
+	results sync.Map // installKey -> *installResult
+
+	reapOnce sync.Once
+}
+
+// installKey identifies a cached install by version selector and
+// platform, so a process can install more than one version.
+type installKey struct {
+	selector string
+	os       string
+	arch     string
+}
+
+type installResult struct {
+	binaryPath string
+	err        error
+}
+
+// NewManager returns a Manager with its onces and results initialized.
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+func (m *Manager) cacheDir() string {
+	if m.CacheDir != "" {
+		return m.CacheDir
+	}
+	return *cacheDir
+}
+
+func (m *Manager) sha256Pin() string {
+	if m.SHA256Pin != "" {
+		return m.SHA256Pin
+	}
+	return *sha256Pin
+}
+
 // Server represents a running chromedriver instance.
 type Server struct {
-	Port int
-	Cmd  *exec.Cmd
+	Port    int
+	Cmd     *exec.Cmd
+	Options Options
+
+	// stop, when set, is used by Stop instead of the local supervised
+	// process shutdown. Remote backends such as RemoteStart use this to
+	// tear down a container.
+	stop func() error
+
+	pgroup   *pgroup
+	wait     *procWait
+	cacheDir string
 }
 
-func init() {
-	binaryPath = filepath.Join(*cacheDir, binaryBase+"-"+*version)
+// procWait lets multiple goroutines observe a process's exit without
+// calling exec.Cmd.Wait more than once.
+type procWait struct {
+	done chan struct{}
+	err  error
+}
+
+func watchProcess(cmd *exec.Cmd) *procWait {
+	w := &procWait{done: make(chan struct{})}
+	go func() {
+		w.err = cmd.Wait()
+		close(w.done)
+	}()
+	return w
+}
+
+// statusResponse is the shape of the chromedriver /status response,
+// trimmed to the field we care about.
+type statusResponse struct {
+	Value struct {
+		Ready bool `json:"ready"`
+	} `json:"value"`
+}
+
+// manifest records what was installed so subsequent runs can skip
+// resolving and downloading again. Selector is the version selector
+// (e.g. "latest", "120", or a full version) that was requested, since
+// a single cache dir's manifest.json can be overwritten by a later
+// install of a different selector.
+type manifest struct {
+	Selector string `json:"selector"`
+	Version  string `json:"version"`
+	Platform string `json:"platform"`
+	URL      string `json:"url"`
+	SHA256   string `json:"sha256"`
+}
+
+// cftDownload is a single platform/url pair as published by the
+// Chrome for Testing JSON endpoints.
+type cftDownload struct {
+	Platform string `json:"platform"`
+	URL      string `json:"url"`
+}
+
+type cftDownloads struct {
+	Chromedriver []cftDownload `json:"chromedriver"`
+}
+
+// knownGoodVersions is the shape of known-good-versions-with-downloads.json.
+type knownGoodVersions struct {
+	Versions []struct {
+		Version   string       `json:"version"`
+		Downloads cftDownloads `json:"downloads"`
+	} `json:"versions"`
+}
+
+// lastKnownGoodVersions is the shape of
+// last-known-good-versions-with-downloads.json.
+type lastKnownGoodVersions struct {
+	Channels map[string]struct {
+		Version   string       `json:"version"`
+		Downloads cftDownloads `json:"downloads"`
+	} `json:"channels"`
+}
+
+// cftPlatform returns the Chrome for Testing platform identifier for
+// the current runtime.GOOS/GOARCH, e.g. "linux64" or "mac-arm64".
+func cftPlatform() (string, error) {
+	return platformFor(runtime.GOOS, runtime.GOARCH)
+}
+
+// platformFor maps a GOOS/GOARCH pair to a Chrome for Testing platform
+// identifier. It is separated from cftPlatform so the mapping can be
+// tested for every platform regardless of which one the tests run on.
+func platformFor(goos, goarch string) (string, error) {
+	switch goos {
+	case "linux":
+		return "linux64", nil
+	case "windows":
+		return "win64", nil
+	case "darwin":
+		if goarch == "arm64" {
+			return "mac-arm64", nil
+		}
+		return "mac-x64", nil
+	}
+	return "", fmt.Errorf(
+		"chromedriver: unsupported platform %s/%s", goos, goarch)
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("fetching %s failed with error %s", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s returned status %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding JSON from %s failed with error %s", url, err)
+	}
+	return nil
+}
+
+// selectorChannel maps a version selector to the Chrome for Testing
+// channel name it should resolve against, or "" if selector is a full
+// version or bare milestone that must be looked up in the known-good
+// versions list instead.
+func selectorChannel(selector string) string {
+	switch selector {
+	case "latest", "stable":
+		return "Stable"
+	case "beta":
+		return "Beta"
+	}
+	return ""
+}
+
+// resolveVersion turns a version selector (a full version, a bare
+// milestone such as "120", or one of "latest"/"stable"/"beta") into a
+// concrete version and download URL for the current platform.
+func resolveVersion(selector string) (resolvedVersion string, url string, err error) {
+	platform, err := cftPlatform()
+	if err != nil {
+		return "", "", err
+	}
+
+	if channel := selectorChannel(selector); channel != "" {
+		var lkgv lastKnownGoodVersions
+		if err := getJSON(lastKnownGoodVersionsURL, &lkgv); err != nil {
+			return "", "", err
+		}
+		return pickFromChannel(lkgv, channel, platform)
+	}
+
+	var kgv knownGoodVersions
+	if err := getJSON(knownGoodVersionsURL, &kgv); err != nil {
+		return "", "", err
+	}
+	return pickFromVersions(kgv, selector, platform)
+}
+
+// pickFromChannel resolves a channel name (e.g. "Stable") to its
+// published version and download URL for platform.
+func pickFromChannel(lkgv lastKnownGoodVersions, channel, platform string) (string, string, error) {
+	entry, ok := lkgv.Channels[channel]
+	if !ok {
+		return "", "", fmt.Errorf(
+			"chromedriver: no channel %s in %s", channel, lastKnownGoodVersionsURL)
+	}
+	url, err := urlForPlatform(entry.Downloads, platform)
+	if err != nil {
+		return "", "", err
+	}
+	return entry.Version, url, nil
+}
+
+// pickFromVersions finds the newest version in kgv matching selector,
+// either exactly or (if selector is a bare milestone such as "120") as
+// a version prefix, and returns it with its download URL for platform.
+func pickFromVersions(kgv knownGoodVersions, selector, platform string) (string, string, error) {
+	milestone := strings.Count(selector, ".") == 0
+	for i := len(kgv.Versions) - 1; i >= 0; i-- {
+		v := kgv.Versions[i]
+		if v.Version == selector || (milestone && strings.HasPrefix(v.Version, selector+".")) {
+			url, err := urlForPlatform(v.Downloads, platform)
+			if err != nil {
+				return "", "", err
+			}
+			return v.Version, url, nil
+		}
+	}
+	return "", "", fmt.Errorf(
+		"chromedriver: no version matching %q found in %s", selector, knownGoodVersionsURL)
 }
 
-func getDownloadURL() string {
-	os := map[string]string{
-		"darwin":  "mac64",
-		"linux":   "linux64",
-		"windows": "win32",
-	}[runtime.GOOS]
-	return downloadBase + *version + "/chromedriver_" + os + ".zip"
+func urlForPlatform(downloads cftDownloads, platform string) (string, error) {
+	for _, d := range downloads.Chromedriver {
+		if d.Platform == platform {
+			return d.URL, nil
+		}
+	}
+	return "", fmt.Errorf(
+		"chromedriver: no download for platform %s", platform)
 }
 
 func getPort() int {
@@ -88,32 +339,144 @@ func exists(file string) bool {
 	return true
 }
 
-// Fetch and install the chromedriver server binary if necessary.
-func install() error {
-	once.Do(func() {
-		installError = realInstall()
+// hashFile returns the hex-encoded sha256 of the file at path. It is
+// used to record the hash of an already cached binary in the manifest
+// when no sha256Pin is set.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("hashing %s failed with error %s", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hashing %s failed with error %s", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func readManifest(path string) (*manifest, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	var m manifest
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, false
+	}
+	return &m, true
+}
+
+func writeManifest(path string, m *manifest) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating manifest %s failed with error %s", path, err)
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(m)
+}
+
+// install fetches and installs the chromedriver binary for selector
+// if necessary, and returns its path. Concurrent calls for the same
+// selector and platform share a single install via a per-key
+// sync.Once; calls for different selectors run independently.
+func (m *Manager) install(selector string) (string, error) {
+	m.reapOnce.Do(func() {
+		reapStaleLocks(m.cacheDir())
+	})
+	key := installKey{selector: selector, os: runtime.GOOS, arch: runtime.GOARCH}
+	onceI, _ := m.onces.LoadOrStore(key, &sync.Once{})
+	onceI.(*sync.Once).Do(func() {
+		binaryPath, err := m.realInstall(selector)
+		m.results.Store(key, &installResult{binaryPath: binaryPath, err: err})
 	})
-	return installError
+	result, _ := m.results.Load(key)
+	res := result.(*installResult)
+	return res.binaryPath, res.err
 }
 
-func realInstall() error {
-	if exists(binaryPath) {
-		return nil
+// manifestMatches reports whether a cached manifest can satisfy a
+// request for selector/platform without re-resolving or
+// re-downloading. It requires the manifest to have been written for
+// this exact selector, not just this platform, since one cache dir's
+// manifest.json is shared across every selector ever installed there.
+func manifestMatches(man *manifest, selector, platform, sha256Pin string) bool {
+	if man.Selector != selector || man.Platform != platform {
+		return false
 	}
+	return sha256Pin == "" || sha256Pin == man.SHA256
+}
 
-	url := getDownloadURL()
-	zipfile, err := httpzip.ReadURL(url)
+func (m *Manager) realInstall(selector string) (string, error) {
+	platform, err := cftPlatform()
 	if err != nil {
-		return fmt.Errorf(
+		return "", err
+	}
+	cacheDir := m.cacheDir()
+	sha256Pin := m.sha256Pin()
+
+	manifestPath := filepath.Join(cacheDir, manifestBase)
+	if man, ok := readManifest(manifestPath); ok && manifestMatches(man, selector, platform, sha256Pin) {
+		resolvedBinaryPath := filepath.Join(cacheDir, binaryBase+"-"+man.Version)
+		if exists(resolvedBinaryPath) {
+			return resolvedBinaryPath, nil
+		}
+	}
+
+	resolvedVersion, url, err := resolveVersion(selector)
+	if err != nil {
+		return "", err
+	}
+	binaryPath := filepath.Join(cacheDir, binaryBase+"-"+resolvedVersion)
+	// sha256Pin is the hash of the downloaded zip, which isn't kept after
+	// extraction, so a cached binary can't be checked against it. Reuse
+	// the cached binary only when no pin is set; otherwise fall through
+	// and re-download so the pin is verified.
+	if exists(binaryPath) && sha256Pin == "" {
+		hexSum, err := hashFile(binaryPath)
+		if err != nil {
+			return "", err
+		}
+		return binaryPath, writeManifest(manifestPath, &manifest{
+			Selector: selector,
+			Version:  resolvedVersion,
+			Platform: platform,
+			URL:      url,
+			SHA256:   hexSum,
+		})
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("fetching zip from %s failed with error %s", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading zip body from %s failed with error %s", url, err)
+	}
+
+	sum := sha256.Sum256(body)
+	hexSum := hex.EncodeToString(sum[:])
+	if sha256Pin != "" && sha256Pin != hexSum {
+		return "", fmt.Errorf(
+			"chromedriver: sha256 mismatch for %s: got %s, want %s",
+			url, hexSum, sha256Pin)
+	}
+
+	zipfile, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return "", fmt.Errorf(
 			"reading zip content from http URL %s failed with error %s .", url, err)
 	}
 	found := false
 	for _, file := range zipfile.File {
-		if file.Name == binaryBase {
+		if filepath.Base(file.Name) == binaryBase || filepath.Base(file.Name) == binaryBase+".exe" {
 			found = true
 			fileReader, err := file.Open()
 			if err != nil {
-				return fmt.Errorf(
+				return "", fmt.Errorf(
 					"error reading file stream for file %s in zip zip file "+
 						"at URL %s with error %s.",
 					binaryBase,
@@ -123,19 +486,19 @@ func realInstall() error {
 			defer fileReader.Close()
 			err = os.MkdirAll(filepath.Dir(binaryPath), os.FileMode(0777))
 			if err != nil {
-				return fmt.Errorf(
+				return "", fmt.Errorf(
 					"creating directory %s to store binary failed with error %s",
 					filepath.Dir(binaryPath), err)
 			}
 			binaryWriter, err := os.Create(binaryPath)
 			if err != nil {
-				return fmt.Errorf(
+				return "", fmt.Errorf(
 					"error creating output file %s: %s", binaryPath, err)
 			}
 			defer binaryWriter.Close()
 			err = binaryWriter.Chmod(os.FileMode(0777))
 			if err != nil {
-				return fmt.Errorf(
+				return "", fmt.Errorf(
 					"Error setting executable bit on file %s with err %s",
 					binaryPath, err)
 			}
@@ -144,25 +507,51 @@ func realInstall() error {
 		}
 	}
 	if !found {
-		return fmt.Errorf(
+		return "", fmt.Errorf(
 			"Could not find file %s in the zip file at URL %s.", binaryBase, url)
 	}
-	return nil
+
+	return binaryPath, writeManifest(manifestPath, &manifest{
+		Selector: selector,
+		Version:  resolvedVersion,
+		Platform: platform,
+		URL:      url,
+		SHA256:   hexSum,
+	})
 }
 
 // Start a new chromedriver server. It is bound to a random port. This
 // will install the server if necessary.
 func Start() (*Server, error) {
-	err := install()
+	return StartWithOptions(Options{})
+}
+
+// StartWithOptions starts a new chromedriver server as Start does, but
+// additionally records opts so that Server.Capabilities can produce
+// the goog:chromeOptions capability needed to launch Chrome with
+// those options. It uses DefaultManager, so it installs whatever
+// version the chromedriver.version flag holds at call time.
+func StartWithOptions(opts Options) (*Server, error) {
+	return DefaultManager.StartWithOptions(opts)
+}
+
+// StartWithOptions starts a new chromedriver server using this
+// Manager's cache dir and sha256 pin, installing *version if it isn't
+// already cached.
+func (m *Manager) StartWithOptions(opts Options) (*Server, error) {
+	binaryPath, err := m.install(*version)
 	if err != nil {
 		return nil, err
 	}
 	port := getPort()
 	cmd := exec.Command(binaryPath, "-port="+strconv.Itoa(port))
-	cmd.Dir = *cacheDir
+	cmd.Dir = m.cacheDir()
+	pgroup := newPGroup(cmd)
 	server := &Server{
-		Port: port,
-		Cmd:  cmd,
+		Port:    port,
+		Cmd:     cmd,
+		Options: opts,
+		pgroup:  pgroup,
 	}
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -177,23 +566,104 @@ func Start() (*Server, error) {
 		return nil, fmt.Errorf("Failed to start binary %s with error %s.",
 			binaryPath, err)
 	}
+	if err := pgroup.attach(cmd); err != nil {
+		cmd.Process.Kill()
+		watchProcess(cmd)
+		return nil, fmt.Errorf("supervising chromedriver process failed with error %s", err)
+	}
+	if err := writeLock(m.cacheDir(), cmd.Process.Pid); err != nil {
+		cmd.Process.Kill()
+		watchProcess(cmd)
+		return nil, fmt.Errorf("writing lockfile for chromedriver process failed with error %s", err)
+	}
+	server.wait = watchProcess(cmd)
 	if *verbose {
 		go io.Copy(os.Stdout, stdout)
 		go io.Copy(os.Stderr, stderr)
 	}
-	// TODO be smarter about this
-	time.Sleep(500 * time.Millisecond)
+	if err := waitUntilReady(server, *startTimeout); err != nil {
+		server.pgroup.kill()
+		removeLock(m.cacheDir(), cmd.Process.Pid)
+		return nil, err
+	}
+	server.cacheDir = m.cacheDir()
 	return server, nil
 }
 
+// waitUntilReady polls the chromedriver /status endpoint until it
+// reports ready, the local process exits, or timeout elapses.
+func waitUntilReady(server *Server, timeout time.Duration) error {
+	ready := make(chan error, 1)
+	go func() {
+		ready <- pollReady(server.URL()+"/status", timeout)
+	}()
+	select {
+	case <-server.wait.done:
+		return fmt.Errorf(
+			"chromedriver process exited before becoming ready: %s", server.wait.err)
+	case err := <-ready:
+		return err
+	}
+}
+
+// pollReady polls statusURL until chromedriver reports ready or
+// timeout elapses.
+func pollReady(statusURL string, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-deadline:
+			return fmt.Errorf(
+				"chromedriver did not become ready within %s", timeout)
+		case <-ticker.C:
+			resp, err := http.Get(statusURL)
+			if err != nil {
+				continue
+			}
+			var status statusResponse
+			decodeErr := json.NewDecoder(resp.Body).Decode(&status)
+			resp.Body.Close()
+			if decodeErr == nil && status.Value.Ready {
+				return nil
+			}
+		}
+	}
+}
+
 // URL returns the webdriver server URL.
 func (s *Server) URL() string {
 	return "http://0.0.0.0:" + strconv.Itoa(s.Port)
 }
 
-// Stop this server.
+// Capabilities returns the goog:chromeOptions capability for this
+// server's Options. Merge it into the desired capabilities passed
+// when creating a webdriver session.
+func (s *Server) Capabilities() map[string]interface{} {
+	return s.Options.ChromeOptions()
+}
+
+// Stop this server. A local server is sent SIGTERM and given
+// chromedriver.stop-grace to exit before it is killed; a remote
+// server (see RemoteStart) has its container removed.
 func (s *Server) Stop() error {
-	return s.Cmd.Process.Kill()
+	if s.stop != nil {
+		return s.stop()
+	}
+	defer removeLock(s.cacheDir, s.Cmd.Process.Pid)
+	if err := s.pgroup.terminate(); err != nil {
+		return s.pgroup.kill()
+	}
+	select {
+	case <-s.wait.done:
+	case <-time.After(*stopGrace):
+		if err := s.pgroup.kill(); err != nil {
+			return err
+		}
+		<-s.wait.done
+	}
+	return nil
 }
 
 // StopOrFatal stops this server, and fatals if it can't be stopped.
@@ -0,0 +1,61 @@
+package chromedriver
+
+import (
+	"os"
+	"runtime"
+)
+
+// Options configures how Chrome itself is launched by a Server. The
+// zero value launches Chrome with its defaults.
+type Options struct {
+	// Headless runs Chrome in headless mode.
+	Headless bool
+	// NoSandbox passes --no-sandbox. It is automatically enabled when
+	// running as root on Linux, where Chrome's sandbox refuses to start.
+	NoSandbox bool
+	// UserDataDir sets a custom --user-data-dir.
+	UserDataDir string
+	// Proxy sets --proxy-server, e.g. "socks5://127.0.0.1:9050".
+	Proxy string
+	// WindowSize sets --window-size, e.g. "1920,1080".
+	WindowSize string
+	// ChromeBinary overrides the Chrome binary chromedriver launches.
+	ChromeBinary string
+	// ExtraArgs are appended to Chrome's command line as-is, e.g.
+	// "--disable-gpu".
+	ExtraArgs []string
+}
+
+// args returns the Chrome command line flags implied by these options.
+func (o Options) args() []string {
+	var args []string
+	if o.Headless {
+		args = append(args, "--headless=new")
+	}
+	if o.NoSandbox || (runtime.GOOS == "linux" && os.Geteuid() == 0) {
+		args = append(args, "--no-sandbox")
+	}
+	if o.UserDataDir != "" {
+		args = append(args, "--user-data-dir="+o.UserDataDir)
+	}
+	if o.Proxy != "" {
+		args = append(args, "--proxy-server="+o.Proxy)
+	}
+	if o.WindowSize != "" {
+		args = append(args, "--window-size="+o.WindowSize)
+	}
+	return append(args, o.ExtraArgs...)
+}
+
+// ChromeOptions returns the goog:chromeOptions capability that
+// requests Chrome be launched with these options. Merge it into the
+// desired capabilities passed when creating a webdriver session.
+func (o Options) ChromeOptions() map[string]interface{} {
+	chromeOptions := map[string]interface{}{
+		"args": o.args(),
+	}
+	if o.ChromeBinary != "" {
+		chromeOptions["binary"] = o.ChromeBinary
+	}
+	return map[string]interface{}{"goog:chromeOptions": chromeOptions}
+}
@@ -0,0 +1,95 @@
+package chromedriver
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// autoNoSandbox mirrors the condition args() uses to add --no-sandbox
+// on its own, so tests get the right expectation whether or not they
+// happen to run as root (e.g. inside a container).
+func autoNoSandbox() bool {
+	return runtime.GOOS == "linux" && os.Geteuid() == 0
+}
+
+// withAutoNoSandbox builds the flags args() would produce for the
+// given headless setting and trailing flags, inserting --no-sandbox
+// in between when running on linux as root, matching args()'s own
+// flag order.
+func withAutoNoSandbox(headless bool, rest []string) []string {
+	var want []string
+	if headless {
+		want = append(want, "--headless=new")
+	}
+	if autoNoSandbox() {
+		want = append(want, "--no-sandbox")
+	}
+	return append(want, rest...)
+}
+
+func TestOptionsArgs(t *testing.T) {
+	cases := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{
+			name: "zero value",
+			opts: Options{},
+			want: withAutoNoSandbox(false, nil),
+		},
+		{
+			name: "headless",
+			opts: Options{Headless: true},
+			want: withAutoNoSandbox(true, nil),
+		},
+		{
+			name: "no sandbox explicit",
+			opts: Options{NoSandbox: true},
+			want: []string{"--no-sandbox"},
+		},
+		{
+			name: "user data dir, proxy, window size, extra args",
+			opts: Options{
+				UserDataDir: "/tmp/profile",
+				Proxy:       "socks5://127.0.0.1:9050",
+				WindowSize:  "1920,1080",
+				ExtraArgs:   []string{"--disable-gpu", "--mute-audio"},
+			},
+			want: withAutoNoSandbox(false, []string{
+				"--user-data-dir=/tmp/profile",
+				"--proxy-server=socks5://127.0.0.1:9050",
+				"--window-size=1920,1080",
+				"--disable-gpu",
+				"--mute-audio",
+			}),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.opts.args()
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("args() = %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestOptionsChromeOptions(t *testing.T) {
+	opts := Options{Headless: true, ChromeBinary: "/opt/chrome/chrome"}
+	caps := opts.ChromeOptions()
+	chromeOptions, ok := caps["goog:chromeOptions"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected goog:chromeOptions to be a map, got %#v", caps["goog:chromeOptions"])
+	}
+	if chromeOptions["binary"] != "/opt/chrome/chrome" {
+		t.Errorf("binary = %#v, want /opt/chrome/chrome", chromeOptions["binary"])
+	}
+	want := withAutoNoSandbox(true, nil)
+	args, ok := chromeOptions["args"].([]string)
+	if !ok || !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %#v, want %#v", chromeOptions["args"], want)
+	}
+}
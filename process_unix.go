@@ -0,0 +1,68 @@
+//go:build !windows
+// +build !windows
+
+package chromedriver
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// pgroup manages the process group chromedriver runs in so it, and
+// any children it spawns, can be terminated together.
+type pgroup struct {
+	pid int
+}
+
+// newPGroup configures cmd to start as the leader of a new process
+// group. It must be called before cmd.Start.
+func newPGroup(cmd *exec.Cmd) *pgroup {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	return &pgroup{}
+}
+
+// attach records the started process's pid, which is also the
+// process group id since newPGroup made it the group leader. It must
+// be called after cmd.Start.
+func (g *pgroup) attach(cmd *exec.Cmd) error {
+	g.pid = cmd.Process.Pid
+	return nil
+}
+
+// terminate sends SIGTERM to the whole process group.
+func (g *pgroup) terminate() error {
+	return signalGroup(g.pid, syscall.SIGTERM)
+}
+
+// kill sends SIGKILL to the whole process group.
+func (g *pgroup) kill() error {
+	return signalGroup(g.pid, syscall.SIGKILL)
+}
+
+func signalGroup(pid int, sig syscall.Signal) error {
+	if err := syscall.Kill(-pid, sig); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("signaling process group %d with %s failed with error %s", pid, sig, err)
+	}
+	return nil
+}
+
+// processAlive reports whether pid refers to a live process.
+func processAlive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+// terminateReapedGroup is used when reaping a lockfile left behind by
+// a previous run, where pid is both the process and its group id.
+func terminateReapedGroup(pid int) error {
+	return signalGroup(pid, syscall.SIGTERM)
+}
+
+// killReapedGroup force-kills a process group found via a stale
+// lockfile.
+func killReapedGroup(pid int) error {
+	return signalGroup(pid, syscall.SIGKILL)
+}
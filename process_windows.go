@@ -0,0 +1,120 @@
+//go:build windows
+// +build windows
+
+package chromedriver
+
+import (
+	"fmt"
+	"os/exec"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// stillActive is STILL_ACTIVE from the Windows SDK. x/sys/windows
+// does not export it as a constant.
+const stillActive = 259
+
+// pgroup assigns chromedriver to a Windows Job Object with
+// JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE, so closing the job handle
+// terminates chromedriver and any children it spawned, mirroring the
+// process-group behavior used on Unix.
+type pgroup struct {
+	job windows.Handle
+}
+
+// newPGroup is a no-op on Windows; the job object is created once the
+// process exists, in attach.
+func newPGroup(cmd *exec.Cmd) *pgroup {
+	return &pgroup{}
+}
+
+// attach creates a job object with kill-on-close semantics and
+// assigns the started process to it. It must be called after
+// cmd.Start.
+func (g *pgroup) attach(cmd *exec.Cmd) error {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return fmt.Errorf("creating job object failed with error %s", err)
+	}
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{
+			LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE,
+		},
+	}
+	if _, err := windows.SetInformationJobObject(
+		job,
+		windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)),
+		uint32(unsafe.Sizeof(info)),
+	); err != nil {
+		windows.CloseHandle(job)
+		return fmt.Errorf("configuring job object failed with error %s", err)
+	}
+	handle, err := windows.OpenProcess(
+		windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		windows.CloseHandle(job)
+		return fmt.Errorf("opening process %d failed with error %s", cmd.Process.Pid, err)
+	}
+	defer windows.CloseHandle(handle)
+	if err := windows.AssignProcessToJobObject(job, handle); err != nil {
+		windows.CloseHandle(job)
+		return fmt.Errorf("assigning process %d to job object failed with error %s", cmd.Process.Pid, err)
+	}
+	g.job = job
+	return nil
+}
+
+// terminate closes the job object, which kills every process in it.
+// Windows has no SIGTERM equivalent, so this is the same as kill.
+func (g *pgroup) terminate() error {
+	return g.kill()
+}
+
+// kill closes the job object, which kills every process in it.
+func (g *pgroup) kill() error {
+	if g.job == 0 {
+		return nil
+	}
+	err := windows.CloseHandle(g.job)
+	g.job = 0
+	if err != nil {
+		return fmt.Errorf("closing job object failed with error %s", err)
+	}
+	return nil
+}
+
+// processAlive reports whether pid refers to a live process.
+func processAlive(pid int) bool {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(handle)
+	var exitCode uint32
+	if err := windows.GetExitCodeProcess(handle, &exitCode); err != nil {
+		return false
+	}
+	return exitCode == stillActive
+}
+
+// terminateReapedGroup force-terminates a process found via a stale
+// lockfile. Windows has no graceful signal, so this kills directly.
+func terminateReapedGroup(pid int) error {
+	return killReapedGroup(pid)
+}
+
+// killReapedGroup force-terminates a process found via a stale
+// lockfile.
+func killReapedGroup(pid int) error {
+	handle, err := windows.OpenProcess(windows.PROCESS_TERMINATE, false, uint32(pid))
+	if err != nil {
+		return fmt.Errorf("opening process %d failed with error %s", pid, err)
+	}
+	defer windows.CloseHandle(handle)
+	if err := windows.TerminateProcess(handle, 1); err != nil {
+		return fmt.Errorf("terminating process %d failed with error %s", pid, err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package chromedriver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RemoteConfig configures a containerized chromedriver launched by
+// RemoteStart.
+type RemoteConfig struct {
+	// Image is the Docker image to run, e.g. "selenoid/chrome" or
+	// "selenium/standalone-chrome". Defaults to "selenoid/chrome".
+	Image string
+	// Tag is the image tag to run. Defaults to "latest".
+	Tag string
+	// ContainerPort is the port chromedriver listens on inside the
+	// container. Defaults to 4444.
+	ContainerPort int
+	// DockerSocket is the path to the Docker Engine API unix socket.
+	// Defaults to "/var/run/docker.sock".
+	DockerSocket string
+	// StartTimeout bounds how long to wait for the container's
+	// chromedriver to become ready. Defaults to 30s.
+	StartTimeout time.Duration
+}
+
+// RemoteStart launches an ephemeral chromedriver container via the
+// Docker Engine API and returns a Server whose URL points at the
+// mapped host port. Stop removes the container. This lets callers who
+// cannot execute a downloaded chromedriver binary (locked-down CI,
+// non-glibc distros, a Chrome version mismatch) reuse the same Server
+// API as Start.
+func RemoteStart(ctx context.Context, cfg RemoteConfig) (*Server, error) {
+	if cfg.Image == "" {
+		cfg.Image = "selenoid/chrome"
+	}
+	if cfg.Tag == "" {
+		cfg.Tag = "latest"
+	}
+	if cfg.ContainerPort == 0 {
+		cfg.ContainerPort = 4444
+	}
+	if cfg.DockerSocket == "" {
+		cfg.DockerSocket = "/var/run/docker.sock"
+	}
+	if cfg.StartTimeout == 0 {
+		cfg.StartTimeout = 30 * time.Second
+	}
+
+	docker := newDockerClient(cfg.DockerSocket)
+	containerPort := strconv.Itoa(cfg.ContainerPort) + "/tcp"
+
+	id, err := docker.createContainer(ctx, cfg.Image+":"+cfg.Tag, containerPort)
+	if err != nil {
+		return nil, err
+	}
+	if err := docker.startContainer(ctx, id); err != nil {
+		docker.removeContainer(context.Background(), id)
+		return nil, err
+	}
+	hostPort, err := docker.mappedPort(ctx, id, containerPort)
+	if err != nil {
+		docker.removeContainer(context.Background(), id)
+		return nil, err
+	}
+
+	server := &Server{
+		Port: hostPort,
+		stop: func() error {
+			return docker.removeContainer(context.Background(), id)
+		},
+	}
+	statusURL := server.URL() + "/status"
+	if err := pollReady(statusURL, cfg.StartTimeout); err != nil {
+		docker.removeContainer(context.Background(), id)
+		return nil, err
+	}
+	return server, nil
+}
+
+// dockerClient is a minimal client for the parts of the Docker Engine
+// HTTP API needed to run an ephemeral container, talking to the
+// daemon over its unix socket to avoid pulling in the full docker
+// client.
+type dockerClient struct {
+	httpClient *http.Client
+}
+
+func newDockerClient(socket string) *dockerClient {
+	return &dockerClient{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+func (d *dockerClient) do(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("encoding docker request body failed with error %s", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://docker"+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("building docker request for %s failed with error %s", path, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker request for %s failed with error %s", path, err)
+	}
+	return resp, nil
+}
+
+// createContainerRequest builds the request body for POST
+// /containers/create: run image with containerPort (e.g. "4444/tcp")
+// exposed and mapped to an auto-assigned host port, removing the
+// container once it stops.
+func createContainerRequest(image, containerPort string) map[string]interface{} {
+	return map[string]interface{}{
+		"Image": image,
+		"ExposedPorts": map[string]interface{}{
+			containerPort: struct{}{},
+		},
+		"HostConfig": map[string]interface{}{
+			"PortBindings": map[string]interface{}{
+				containerPort: []map[string]string{{"HostPort": ""}},
+			},
+			"AutoRemove": true,
+		},
+	}
+}
+
+func (d *dockerClient) createContainer(ctx context.Context, image, containerPort string) (string, error) {
+	resp, err := d.do(ctx, "POST", "/containers/create", createContainerRequest(image, containerPort))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf(
+			"creating container from image %s failed with status %s: %s",
+			image, resp.Status, body)
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("decoding create container response failed with error %s", err)
+	}
+	return created.ID, nil
+}
+
+func (d *dockerClient) startContainer(ctx context.Context, id string) error {
+	resp, err := d.do(ctx, "POST", "/containers/"+id+"/start", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf(
+			"starting container %s failed with status %s: %s", id, resp.Status, body)
+	}
+	return nil
+}
+
+// portBinding is a single entry of NetworkSettings.Ports in a
+// container inspect response.
+type portBinding struct {
+	HostPort string `json:"HostPort"`
+}
+
+// containerInspect is the subset of GET /containers/{id}/json that
+// mappedPort needs.
+type containerInspect struct {
+	NetworkSettings struct {
+		Ports map[string][]portBinding `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+// firstHostPort returns the host port Docker mapped containerPort to,
+// the first entry of bindings for that port.
+func firstHostPort(inspect containerInspect, containerPort string) (int, error) {
+	bindings := inspect.NetworkSettings.Ports[containerPort]
+	if len(bindings) == 0 {
+		return 0, fmt.Errorf("no host binding for port %s", containerPort)
+	}
+	hostPort, err := strconv.Atoi(bindings[0].HostPort)
+	if err != nil {
+		return 0, fmt.Errorf("parsing host port %q failed with error %s", bindings[0].HostPort, err)
+	}
+	return hostPort, nil
+}
+
+func (d *dockerClient) mappedPort(ctx context.Context, id, containerPort string) (int, error) {
+	resp, err := d.do(ctx, "GET", "/containers/"+id+"/json", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf(
+			"inspecting container %s failed with status %s: %s", id, resp.Status, body)
+	}
+	var inspect containerInspect
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return 0, fmt.Errorf("decoding inspect response for container %s failed with error %s", id, err)
+	}
+	hostPort, err := firstHostPort(inspect, containerPort)
+	if err != nil {
+		return 0, fmt.Errorf("container %s: %s", id, err)
+	}
+	return hostPort, nil
+}
+
+func (d *dockerClient) removeContainer(ctx context.Context, id string) error {
+	resp, err := d.do(ctx, "DELETE", "/containers/"+id+"?force=true", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf(
+			"removing container %s failed with status %s: %s", id, resp.Status, body)
+	}
+	return nil
+}
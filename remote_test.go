@@ -0,0 +1,57 @@
+package chromedriver
+
+import "testing"
+
+func TestCreateContainerRequest(t *testing.T) {
+	req := createContainerRequest("selenoid/chrome:latest", "4444/tcp")
+
+	if req["Image"] != "selenoid/chrome:latest" {
+		t.Errorf("Image = %#v, want selenoid/chrome:latest", req["Image"])
+	}
+	exposed, ok := req["ExposedPorts"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("ExposedPorts is not a map: %#v", req["ExposedPorts"])
+	}
+	if _, ok := exposed["4444/tcp"]; !ok {
+		t.Errorf("ExposedPorts missing 4444/tcp: %#v", exposed)
+	}
+	hostConfig, ok := req["HostConfig"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("HostConfig is not a map: %#v", req["HostConfig"])
+	}
+	if hostConfig["AutoRemove"] != true {
+		t.Errorf("AutoRemove = %#v, want true", hostConfig["AutoRemove"])
+	}
+	bindings, ok := hostConfig["PortBindings"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("PortBindings is not a map: %#v", hostConfig["PortBindings"])
+	}
+	ports, ok := bindings["4444/tcp"].([]map[string]string)
+	if !ok || len(ports) != 1 || ports[0]["HostPort"] != "" {
+		t.Errorf("PortBindings[4444/tcp] = %#v, want a single binding to an auto-assigned host port", bindings["4444/tcp"])
+	}
+}
+
+func TestFirstHostPort(t *testing.T) {
+	inspect := containerInspect{}
+	inspect.NetworkSettings.Ports = map[string][]portBinding{
+		"4444/tcp": {{HostPort: "32768"}},
+	}
+
+	port, err := firstHostPort(inspect, "4444/tcp")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if port != 32768 {
+		t.Errorf("port = %d, want 32768", port)
+	}
+
+	if _, err := firstHostPort(inspect, "9999/tcp"); err == nil {
+		t.Error("expected error for unmapped port, got none")
+	}
+
+	inspect.NetworkSettings.Ports["5555/tcp"] = []portBinding{{HostPort: "not-a-number"}}
+	if _, err := firstHostPort(inspect, "5555/tcp"); err == nil {
+		t.Error("expected error for unparseable host port, got none")
+	}
+}
@@ -0,0 +1,63 @@
+package chromedriver
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var stopGrace = flag.Duration(
+	"chromedriver.stop-grace",
+	5*time.Second,
+	"how long to wait after a graceful stop before killing chromedriver")
+
+func lockDir(cacheDir string) string {
+	return filepath.Join(cacheDir, "locks")
+}
+
+func lockPath(cacheDir string, pid int) string {
+	return filepath.Join(lockDir(cacheDir), strconv.Itoa(pid)+".lock")
+}
+
+// writeLock records pid in the cache dir's lock directory so a future
+// process can reap it if this one crashes without stopping it.
+func writeLock(cacheDir string, pid int) error {
+	if err := os.MkdirAll(lockDir(cacheDir), os.FileMode(0777)); err != nil {
+		return err
+	}
+	return os.WriteFile(lockPath(cacheDir, pid), []byte(strconv.Itoa(pid)), os.FileMode(0666))
+}
+
+func removeLock(cacheDir string, pid int) {
+	os.Remove(lockPath(cacheDir, pid))
+}
+
+// reapStaleLocks scans the cache dir for lockfiles left behind by a
+// previous run that crashed before it could Stop its chromedriver
+// process, and terminates anything still alive. It is run lazily by
+// Manager on first use rather than from a package init, since flags
+// like chromedriver.cache-dir aren't parsed yet when inits run.
+func reapStaleLocks(cacheDir string) {
+	entries, err := os.ReadDir(lockDir(cacheDir))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		pid, err := strconv.Atoi(strings.TrimSuffix(name, ".lock"))
+		if err != nil {
+			continue
+		}
+		if processAlive(pid) {
+			terminateReapedGroup(pid)
+			time.Sleep(100 * time.Millisecond)
+			if processAlive(pid) {
+				killReapedGroup(pid)
+			}
+		}
+		os.Remove(filepath.Join(lockDir(cacheDir), name))
+	}
+}
@@ -0,0 +1,204 @@
+package chromedriver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlatformFor(t *testing.T) {
+	cases := []struct {
+		goos, goarch string
+		want         string
+		wantErr      bool
+	}{
+		{"linux", "amd64", "linux64", false},
+		{"linux", "arm64", "linux64", false},
+		{"windows", "amd64", "win64", false},
+		{"darwin", "amd64", "mac-x64", false},
+		{"darwin", "arm64", "mac-arm64", false},
+		{"plan9", "amd64", "", true},
+	}
+	for _, c := range cases {
+		got, err := platformFor(c.goos, c.goarch)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("platformFor(%s, %s): expected error, got none", c.goos, c.goarch)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("platformFor(%s, %s): unexpected error %s", c.goos, c.goarch, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("platformFor(%s, %s) = %q, want %q", c.goos, c.goarch, got, c.want)
+		}
+	}
+}
+
+func TestSelectorChannel(t *testing.T) {
+	cases := []struct {
+		selector string
+		want     string
+	}{
+		{"latest", "Stable"},
+		{"stable", "Stable"},
+		{"beta", "Beta"},
+		{"120", ""},
+		{"120.0.6099.109", ""},
+	}
+	for _, c := range cases {
+		if got := selectorChannel(c.selector); got != c.want {
+			t.Errorf("selectorChannel(%q) = %q, want %q", c.selector, got, c.want)
+		}
+	}
+}
+
+func TestUrlForPlatform(t *testing.T) {
+	downloads := cftDownloads{
+		Chromedriver: []cftDownload{
+			{Platform: "linux64", URL: "https://example.com/linux64.zip"},
+			{Platform: "mac-arm64", URL: "https://example.com/mac-arm64.zip"},
+		},
+	}
+	url, err := urlForPlatform(downloads, "linux64")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if url != "https://example.com/linux64.zip" {
+		t.Errorf("got %q, want linux64 URL", url)
+	}
+	if _, err := urlForPlatform(downloads, "win64"); err == nil {
+		t.Error("expected error for missing platform, got none")
+	}
+}
+
+func TestPickFromChannel(t *testing.T) {
+	lkgv := lastKnownGoodVersions{
+		Channels: map[string]struct {
+			Version   string       `json:"version"`
+			Downloads cftDownloads `json:"downloads"`
+		}{
+			"Stable": {
+				Version: "120.0.6099.109",
+				Downloads: cftDownloads{
+					Chromedriver: []cftDownload{
+						{Platform: "linux64", URL: "https://example.com/stable-linux64.zip"},
+					},
+				},
+			},
+		},
+	}
+
+	version, url, err := pickFromChannel(lkgv, "Stable", "linux64")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if version != "120.0.6099.109" || url != "https://example.com/stable-linux64.zip" {
+		t.Errorf("got (%q, %q), want (120.0.6099.109, stable-linux64.zip URL)", version, url)
+	}
+
+	if _, _, err := pickFromChannel(lkgv, "Beta", "linux64"); err == nil {
+		t.Error("expected error for missing channel, got none")
+	}
+	if _, _, err := pickFromChannel(lkgv, "Stable", "win64"); err == nil {
+		t.Error("expected error for missing platform, got none")
+	}
+}
+
+func TestManifestMatches(t *testing.T) {
+	man := &manifest{Selector: "120", Platform: "linux64", SHA256: "abc"}
+
+	if !manifestMatches(man, "120", "linux64", "") {
+		t.Error("expected match on same selector and platform with no pin")
+	}
+	if !manifestMatches(man, "120", "linux64", "abc") {
+		t.Error("expected match when pin equals cached sha256")
+	}
+	if manifestMatches(man, "119", "linux64", "") {
+		t.Error("a manifest written for selector \"120\" must not satisfy selector \"119\"")
+	}
+	if manifestMatches(man, "120", "win64", "") {
+		t.Error("expected no match on different platform")
+	}
+	if manifestMatches(man, "120", "linux64", "def") {
+		t.Error("expected no match when pin disagrees with cached sha256")
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "binary")
+	if err := os.WriteFile(path, []byte("chromedriver"), 0777); err != nil {
+		t.Fatalf("writing fixture file failed: %s", err)
+	}
+
+	const want = "89f97466182160f58629a195c742d24462a08da6019e061bd2f2c7b2f5744029"
+	got, err := hashFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got != want {
+		t.Errorf("hashFile(%q) = %q, want %q", path, got, want)
+	}
+
+	if _, err := hashFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for a missing file, got none")
+	}
+}
+
+func TestPickFromVersions(t *testing.T) {
+	kgv := knownGoodVersions{
+		Versions: []struct {
+			Version   string       `json:"version"`
+			Downloads cftDownloads `json:"downloads"`
+		}{
+			{
+				Version: "119.0.6045.0",
+				Downloads: cftDownloads{
+					Chromedriver: []cftDownload{{Platform: "linux64", URL: "https://example.com/119.zip"}},
+				},
+			},
+			{
+				Version: "120.0.6099.56",
+				Downloads: cftDownloads{
+					Chromedriver: []cftDownload{{Platform: "linux64", URL: "https://example.com/120.0.6099.56.zip"}},
+				},
+			},
+			{
+				Version: "120.0.6099.109",
+				Downloads: cftDownloads{
+					Chromedriver: []cftDownload{{Platform: "linux64", URL: "https://example.com/120.0.6099.109.zip"}},
+				},
+			},
+		},
+	}
+
+	// A bare milestone picks the newest matching version, not the first.
+	version, url, err := pickFromVersions(kgv, "120", "linux64")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if version != "120.0.6099.109" || url != "https://example.com/120.0.6099.109.zip" {
+		t.Errorf("got (%q, %q), want the newest 120.x version", version, url)
+	}
+
+	// An exact version matches only itself.
+	version, _, err = pickFromVersions(kgv, "119.0.6045.0", "linux64")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if version != "119.0.6045.0" {
+		t.Errorf("got %q, want exact match 119.0.6045.0", version)
+	}
+
+	// "12" must not prefix-match "120.x" versions.
+	if _, _, err := pickFromVersions(kgv, "12", "linux64"); err == nil {
+		t.Error("expected no match for bare selector \"12\", got none")
+	}
+
+	if _, _, err := pickFromVersions(kgv, "121", "linux64"); err == nil {
+		t.Error("expected error for unknown milestone, got none")
+	}
+}